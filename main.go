@@ -2,15 +2,20 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
+	"hash/maphash"
 	"log"
 	"net/url"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"unicode"
+
+	"github.com/BurntSushi/toml"
 )
 
 // TokenType defines the origin of the token within a URL.
@@ -29,23 +34,70 @@ const (
 type Token struct {
 	Value string
 	Type  TokenType
+	// Line is the 0-based index of the input line the token came from.
+	// Only -mine-substrings consumes it; every other pipeline ignores it.
+	Line int
 }
 
 var (
-	minlength      int
-	maxlength      int
-	alphaNumOnly   bool
-	filterString   string
-	filterRegex    string
-	pathOutputFile string
-	paramOutputFile string
+	minlength            int
+	maxlength            int
+	alphaNumOnly         bool
+	filterString         string
+	filterRegex          string
+	pathOutputFile       string
+	paramOutputFile      string
+	subdomainOutputFile  string
+	paramValueOutputFile string
+	fragmentOutputFile   string
+	splitCase            bool
+	maxDepth             int
+	countMode            bool
+	topN                 int
+	withCounts           bool
+	bloomMode            bool
+	bloomBits            uint64
+	bloomHashes          int
+	mineSubstrings       bool
+	mineMinLen           int
+	mineMinDocs          int
+	configPath           string
 
 	regex *regexp.Regexp
 
-	pathFile *os.File
-	paramFile *os.File
+	pathFile       *os.File
+	paramFile      *os.File
+	subdomainFile  *os.File
+	paramValueFile *os.File
+	fragmentFile   *os.File
+
+	// lastToken is the default dedup path: exact first-seen-wins tracking.
+	// -bloom swaps this out for bloomFilter, trading exactness for bounded
+	// memory on large corpora.
+	lastToken   sync.Map
+	bloomFilter *countingBloomFilter
+
+	// miner accumulates every token (with its source line) for
+	// -mine-substrings. It is only written by the single token worker
+	// goroutine and only read by flushSubstringMining after wg.Wait(), so
+	// it needs no locking.
+	miner substringMiner
+
+	// counts holds per-TokenType token frequencies when -count is set.
+	// It is only written by the single token-processing worker goroutine
+	// during ingest and only read by flushCounts after wg.Wait(), so it
+	// needs no locking.
+	counts = make(map[TokenType]map[string]int)
 
-	lastToken sync.Map
+	// rules drives the default (non-count/mine) pipeline, after either the
+	// exact or the -bloom dedup. Without
+	// -config it's synthesized from the flags above by defaultRules; with
+	// -config it's loaded from the TOML rule file by loadRuleConfig.
+	rules []compiledRule
+
+	// configOutputFiles caches the output files opened for -config rules,
+	// keyed by path, so rules sharing an output only open it once.
+	configOutputFiles = make(map[string]*os.File)
 )
 
 func main() {
@@ -56,8 +108,27 @@ func main() {
 	flag.StringVar(&filterRegex, "r", "", "filter tokens to those matching this regex pattern")
 	flag.StringVar(&pathOutputFile, "o", "", "output file for path tokens")
 	flag.StringVar(&paramOutputFile, "op", "", "output file for parameter name tokens")
+	flag.StringVar(&subdomainOutputFile, "os", "", "output file for subdomain tokens")
+	flag.StringVar(&paramValueOutputFile, "ofv", "", "output file for parameter value tokens")
+	flag.StringVar(&fragmentOutputFile, "of", "", "output file for fragment tokens")
+	flag.BoolVar(&splitCase, "split-case", false, "also split identifier-style tokens on camelCase boundaries (snake_case/kebab-case are already split by the base tokenizer)")
+	flag.IntVar(&maxDepth, "max-depth", 3, "max recursion depth when expanding percent-encoded or nested query values")
+	flag.BoolVar(&countMode, "count", false, "count token frequency per TokenType instead of first-seen dedup")
+	flag.IntVar(&topN, "top", 0, "with -count, only emit the top N most frequent tokens per TokenType (0 = all)")
+	flag.BoolVar(&withCounts, "with-counts", false, "with -count, prefix each emitted token with its count and a tab")
+	flag.BoolVar(&bloomMode, "bloom", false, "dedup with a sharded counting Bloom filter instead of the exact sync.Map (bounded memory, small false-positive rate on large corpora)")
+	flag.Uint64Var(&bloomBits, "bloom-bits", 64*1024*1024, "total bloom filter size in slots (each slot is a saturating uint8 counter, so this is also the filter's memory size in bytes; default ~64 MiB, sized for ~10M items at ~4% FPR with the default -bloom-hashes)")
+	flag.IntVar(&bloomHashes, "bloom-hashes", 4, "number of hash functions (k) the bloom filter checks/sets per token")
+	flag.BoolVar(&mineSubstrings, "mine-substrings", false, "mine recurring substrings across tokens via a suffix array instead of emitting raw tokens")
+	flag.IntVar(&mineMinLen, "mine-min-len", 4, "with -mine-substrings, minimum substring length to report")
+	flag.IntVar(&mineMinDocs, "mine-min-docs", 2, "with -mine-substrings, minimum number of distinct source lines a substring must occur in to be reported")
+	flag.StringVar(&configPath, "config", "", "path to a TOML rule file ([[rule]] array) describing per-TokenType filter/output pipelines, replacing -f/-r/-min/-max/-alpha-num-only/-o/-op/-os/-ofv/-of (-count and -mine-substrings ignore it and still use those flags)")
 	flag.Parse()
 
+	if bloomMode {
+		bloomFilter = newCountingBloomFilter(bloomBits, bloomHashes)
+	}
+
 	var err error
 	if filterRegex != "" {
 		regex, err = regexp.Compile(filterRegex)
@@ -66,22 +137,38 @@ func main() {
 		}
 	}
 
-	if pathOutputFile != "" {
-		pathFile, err = os.Create(pathOutputFile)
+	openOutput := func(path string, dst **os.File) {
+		if path == "" {
+			return
+		}
+		f, err := os.Create(path)
 		if err != nil {
-			log.Fatalf("failed to create path output file: %v", err)
+			log.Fatalf("failed to create output file %q: %v", path, err)
 		}
-		defer pathFile.Close()
+		*dst = f
 	}
-
-	if paramOutputFile != "" {
-		paramFile, err = os.Create(paramOutputFile)
-		if err != nil {
-			log.Fatalf("failed to create param output file: %v", err)
+	openOutput(pathOutputFile, &pathFile)
+	openOutput(paramOutputFile, &paramFile)
+	openOutput(subdomainOutputFile, &subdomainFile)
+	openOutput(paramValueOutputFile, &paramValueFile)
+	openOutput(fragmentOutputFile, &fragmentFile)
+	for _, f := range []*os.File{pathFile, paramFile, subdomainFile, paramValueFile, fragmentFile} {
+		if f != nil {
+			defer f.Close()
 		}
-		defer paramFile.Close()
 	}
 
+	if configPath != "" {
+		rules = loadRuleConfig(configPath)
+	} else {
+		rules = defaultRules()
+	}
+	defer func() {
+		for _, f := range configOutputFiles {
+			f.Close()
+		}
+	}()
+
 	tokens := make(chan Token)
 	var wg sync.WaitGroup
 
@@ -96,83 +183,226 @@ func main() {
 
 	// Main goroutine to read stdin and produce tokens
 	scanner := bufio.NewScanner(os.Stdin)
+	lineID := 0
 	for scanner.Scan() {
 		line := scanner.Text()
 		u, err := url.Parse(line)
 		if err != nil || u.Scheme == "" || u.Host == "" {
 			// Fallback to simple tokenization for non-URLs
-			subTokenize(line, Generic, tokens)
+			subTokenize(line, Generic, lineID, tokens)
+			lineID++
 			continue
 		}
 
-		// Hostname -> Subdomains
-		hostname := u.Hostname()
-		for _, part := range strings.Split(hostname, ".") {
-			subTokenize(part, Subdomain, tokens)
-		}
+		processURL(u, tokens, 0, lineID)
+		lineID++
+	}
+
+	close(tokens)
+	wg.Wait()
+
+	if countMode {
+		flushCounts()
+	}
+	if mineSubstrings {
+		flushSubstringMining()
+	}
+}
 
-		// Path
-		for _, part := range strings.Split(u.Path, "/") {
-			subTokenize(part, Path, tokens)
+// processURL tokenizes the hostname, path, query and fragment of a parsed
+// URL. depth tracks how many levels of percent-decoded/nested query values
+// have already been expanded, so expandValue can stop at -max-depth. line is
+// the source line id, carried along for -mine-substrings.
+func processURL(u *url.URL, tokens chan<- Token, depth, line int) {
+	// Hostname -> Subdomains
+	hostname := u.Hostname()
+	for _, part := range strings.Split(hostname, ".") {
+		subTokenize(part, Subdomain, line, tokens)
+	}
+
+	// Path
+	for _, part := range strings.Split(u.Path, "/") {
+		subTokenize(part, Path, line, tokens)
+	}
+
+	// Query Parameters
+	query, err := url.ParseQuery(u.RawQuery)
+	if err == nil {
+		for key, values := range query {
+			subTokenize(key, ParamName, line, tokens)
+			for _, value := range values {
+				// expandValue runs before the flat subTokenize fallback so
+				// that, when value is itself a nested URL/query, its
+				// components get dedup'd in under their correct
+				// Subdomain/Path/ParamName types first. If it ran second,
+				// the flat ParamValue split below would already have
+				// claimed those same strings in lastToken/the bloom
+				// filter (dedup is keyed on the string alone, not
+				// (Type, string)), and the correctly-typed re-emissions
+				// would be silently dropped.
+				expandValue(value, tokens, depth, line)
+				subTokenize(value, ParamValue, line, tokens)
+			}
 		}
+	}
 
-		// Query Parameters
-		query, err := url.ParseQuery(u.RawQuery)
-		if err == nil {
-			for key, values := range query {
-				subTokenize(key, ParamName, tokens)
-				for _, value := range values {
-					subTokenize(value, ParamValue, tokens)
-				}
+	// Fragment
+	subTokenize(u.Fragment, Fragment, line, tokens)
+}
+
+// expandValue percent-decodes a query value and, if the decoded form is
+// itself a URL or a "key=value&..." query string, re-feeds it through
+// processURL/the query tokenization pipeline (preserving TokenType).
+// Otherwise the decoded value is tokenized as ParamValue. The structural
+// checks run even when decoding was a no-op, since url.ParseQuery (called
+// by processURL before expandValue ever sees value) has already decoded one
+// percent-encoding layer, so a single-encoded nested URL/query reaches here
+// already in decoded form; "no change" only means there's nothing further
+// to decode, not that there's no structure to recurse into. Recursion stops
+// once depth reaches -max-depth, guarding against pathological inputs.
+func expandValue(value string, tokens chan<- Token, depth, line int) {
+	if depth >= maxDepth {
+		return
+	}
+
+	decoded, err := url.QueryUnescape(value)
+	if err != nil {
+		return
+	}
+
+	if nested, err := url.Parse(decoded); err == nil && nested.Scheme != "" && nested.Host != "" {
+		processURL(nested, tokens, depth+1, line)
+		return
+	}
+
+	if nestedQuery, err := url.ParseQuery(decoded); err == nil && len(nestedQuery) > 0 && strings.ContainsAny(decoded, "=&") {
+		for key, values := range nestedQuery {
+			subTokenize(key, ParamName, line, tokens)
+			for _, v := range values {
+				// See the ordering note in processURL: claim the deeper
+				// recursion's correctly-typed tokens before the flat
+				// ParamValue fallback can dedup them away.
+				expandValue(v, tokens, depth+1, line)
+				subTokenize(v, ParamValue, line, tokens)
 			}
 		}
-		
-		// Fragment
-		subTokenize(u.Fragment, Fragment, tokens)
+		return
 	}
 
-	close(tokens)
-	wg.Wait()
+	if decoded == value {
+		return
+	}
+
+	subTokenize(decoded, ParamValue, line, tokens)
 }
 
 // subTokenize performs basic tokenization on a string part.
-func subTokenize(input string, tokenType TokenType, tokens chan<- Token) {
+func subTokenize(input string, tokenType TokenType, line int, tokens chan<- Token) {
 	var out strings.Builder
+	emit := func(s string) {
+		tokens <- Token{Value: s, Type: tokenType, Line: line}
+		if splitCase {
+			for _, part := range splitIdentifier(s) {
+				if part != s {
+					tokens <- Token{Value: part, Type: tokenType, Line: line}
+				}
+			}
+		}
+	}
 	for _, r := range input {
 		if unicode.IsLetter(r) || unicode.IsNumber(r) {
 			out.WriteRune(r)
 		} else {
 			if out.Len() > 0 {
-				tokens <- Token{Value: out.String(), Type: tokenType}
+				emit(out.String())
 				out.Reset()
 			}
 		}
 	}
 	if out.Len() > 0 {
-		tokens <- Token{Value: out.String(), Type: tokenType}
+		emit(out.String())
 	}
 }
 
-// processToken filters a token and writes it to the appropriate output.
+// splitIdentifier breaks an identifier-style string into its component
+// words on camelCase boundaries (including the "acronym followed by Word"
+// rule, so "XMLHttpRequest" yields "XML", "Http", "Request"). Underscore
+// and hyphen are not handled here: subTokenize already splits on any
+// non-letter/non-number rune before -split-case ever runs, so by the time a
+// string reaches splitIdentifier it can't contain '_' or '-' in the first
+// place.
+func splitIdentifier(s string) []string {
+	return splitCamelCase(s)
+}
+
+// splitCamelCase splits s on lowercase->uppercase boundaries and on the
+// boundary between a run of uppercase letters and the capitalized word
+// that follows it (e.g. "XMLHttp" -> "XML", "Http").
+func splitCamelCase(s string) []string {
+	runes := []rune(s)
+	var words []string
+	var cur []rune
+	for i, r := range runes {
+		if i > 0 {
+			prev := runes[i-1]
+			lowerToUpper := unicode.IsLower(prev) && unicode.IsUpper(r)
+			acronymToWord := unicode.IsUpper(prev) && unicode.IsUpper(r) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if lowerToUpper || acronymToWord {
+				words = append(words, string(cur))
+				cur = nil
+			}
+		}
+		cur = append(cur, r)
+	}
+	if len(cur) > 0 {
+		words = append(words, string(cur))
+	}
+	return words
+}
+
+// processToken is the ingest phase: it filters a token and either writes it
+// straight to its output (default first-seen-wins dedup) or, in -count
+// mode, folds it into the per-TokenType frequency map for flushCounts to
+// sort and emit once the stream ends.
 func processToken(token Token) {
 	str := token.Value
-	
+
+	if mineSubstrings {
+		miner.add(str, token.Line)
+		return
+	}
+
+	if countMode {
+		ingestCount(token.Type, str)
+		return
+	}
+
 	// Deduplication
-	if _, loaded := lastToken.LoadOrStore(str, true); loaded {
+	if bloomMode {
+		if bloomFilter.seen(str) {
+			return
+		}
+	} else if _, loaded := lastToken.LoadOrStore(str, true); loaded {
 		return
 	}
 
-	// Filters
+	evaluateRules(token.Type, str)
+}
+
+// passesFilters applies the min/max length, substring, regex and
+// alpha-num-only filters shared by the -count and -mine-substrings
+// pipelines (the default pipeline filters per rule, in evaluateRules).
+func passesFilters(str string) bool {
 	if len(str) < minlength || len(str) > maxlength {
-		return
+		return false
 	}
 
 	if filterString != "" && !strings.Contains(str, filterString) {
-		return
+		return false
 	}
 
 	if regex != nil && !regex.MatchString(str) {
-		return
+		return false
 	}
 
 	if alphaNumOnly {
@@ -187,12 +417,410 @@ func processToken(token Token) {
 			}
 		}
 		if !hasLetter || !hasNumber {
+			return false
+		}
+	}
+
+	return true
+}
+
+// tokenTypeByName maps the TokenType names used in rule config files back
+// to their TokenType.
+var tokenTypeByName = map[string]TokenType{
+	"Subdomain":  Subdomain,
+	"Path":       Path,
+	"ParamName":  ParamName,
+	"ParamValue": ParamValue,
+	"Fragment":   Fragment,
+	"Generic":    Generic,
+}
+
+// RuleConfig is the top-level shape of a -config TOML file: an ordered
+// array of rules, each scoped to a set of TokenTypes.
+type RuleConfig struct {
+	Rule []RuleSpec `toml:"rule"`
+}
+
+// RuleSpec is one [[rule]] entry as decoded straight from TOML, before its
+// regexes are compiled and its output file resolved by compileRule.
+type RuleSpec struct {
+	Types        []string `toml:"types"`
+	Min          int      `toml:"min"`
+	Max          int      `toml:"max"`
+	Contains     string   `toml:"contains"`
+	Regex        string   `toml:"regex"`
+	NotRegex     string   `toml:"not_regex"`
+	AlphaNumOnly bool     `toml:"alpha_num_only"`
+	Lowercase    bool     `toml:"lowercase"`
+	Output       string   `toml:"output"`
+	Stop         bool     `toml:"stop"`
+}
+
+// compiledRule is a RuleSpec with its regexes compiled and its output file
+// resolved, ready for evaluateRules to apply per token.
+type compiledRule struct {
+	types        map[TokenType]bool // nil/empty matches every TokenType
+	min, max     int
+	contains     string
+	regex        *regexp.Regexp
+	notRegex     *regexp.Regexp
+	alphaNumOnly bool
+	lowercase    bool
+	output       *os.File // nil means stdout
+	stop         bool
+}
+
+// matchesType reports whether the rule applies to t. An empty types set
+// matches every TokenType.
+func (r *compiledRule) matchesType(t TokenType) bool {
+	if len(r.types) == 0 {
+		return true
+	}
+	return r.types[t]
+}
+
+// passes applies the rule's own filters to s, mirroring passesFilters but
+// per-rule and adding not_regex.
+func (r *compiledRule) passes(s string) bool {
+	if r.min > 0 && len(s) < r.min {
+		return false
+	}
+	if r.max > 0 && len(s) > r.max {
+		return false
+	}
+	if r.contains != "" && !strings.Contains(s, r.contains) {
+		return false
+	}
+	if r.regex != nil && !r.regex.MatchString(s) {
+		return false
+	}
+	if r.notRegex != nil && r.notRegex.MatchString(s) {
+		return false
+	}
+	if r.alphaNumOnly {
+		hasLetter := false
+		hasNumber := false
+		for _, c := range s {
+			if unicode.IsLetter(c) {
+				hasLetter = true
+			}
+			if unicode.IsNumber(c) {
+				hasNumber = true
+			}
+		}
+		if !hasLetter || !hasNumber {
+			return false
+		}
+	}
+	return true
+}
+
+// compileRule resolves a decoded RuleSpec into a compiledRule: it compiles
+// regex/not_regex, maps type names to TokenTypes, and resolves the output
+// file (creating and caching it in configOutputFiles on first use).
+func compileRule(spec RuleSpec) compiledRule {
+	var types map[TokenType]bool
+	if len(spec.Types) > 0 {
+		types = make(map[TokenType]bool, len(spec.Types))
+		for _, name := range spec.Types {
+			t, ok := tokenTypeByName[name]
+			if !ok {
+				log.Fatalf("rule config: unknown token type %q", name)
+			}
+			types[t] = true
+		}
+	}
+
+	var re *regexp.Regexp
+	if spec.Regex != "" {
+		var err error
+		re, err = regexp.Compile(spec.Regex)
+		if err != nil {
+			log.Fatalf("rule config: invalid regex %q: %v", spec.Regex, err)
+		}
+	}
+
+	var notRe *regexp.Regexp
+	if spec.NotRegex != "" {
+		var err error
+		notRe, err = regexp.Compile(spec.NotRegex)
+		if err != nil {
+			log.Fatalf("rule config: invalid not_regex %q: %v", spec.NotRegex, err)
+		}
+	}
+
+	return compiledRule{
+		types:        types,
+		min:          spec.Min,
+		max:          spec.Max,
+		contains:     spec.Contains,
+		regex:        re,
+		notRegex:     notRe,
+		alphaNumOnly: spec.AlphaNumOnly,
+		lowercase:    spec.Lowercase,
+		output:       resolveOutput(spec.Output),
+		stop:         spec.Stop,
+	}
+}
+
+// resolveOutput returns the *os.File for a rule's output setting: nil (and
+// so stdout) for "" or "stdout", otherwise the file at that path, created
+// and cached in configOutputFiles the first time it's referenced so rules
+// sharing a path share a handle.
+func resolveOutput(path string) *os.File {
+	if path == "" || strings.EqualFold(path, "stdout") {
+		return nil
+	}
+	if f, ok := configOutputFiles[path]; ok {
+		return f
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("rule config: failed to create output file %q: %v", path, err)
+	}
+	configOutputFiles[path] = f
+	return f
+}
+
+// loadRuleConfig reads and compiles the [[rule]] array from a -config TOML
+// file.
+func loadRuleConfig(path string) []compiledRule {
+	var cfg RuleConfig
+	if _, err := toml.DecodeFile(path, &cfg); err != nil {
+		log.Fatalf("failed to load rule config %q: %v", path, err)
+	}
+
+	compiled := make([]compiledRule, 0, len(cfg.Rule))
+	for _, spec := range cfg.Rule {
+		compiled = append(compiled, compileRule(spec))
+	}
+	return compiled
+}
+
+// defaultRules synthesizes the config-rule equivalent of the legacy
+// -f/-r/-min/-max/-alpha-num-only/-o/-op/-os/-ofv/-of flags: one rule per
+// TokenType, sharing the same filters, each writing to that type's
+// already-opened output file (or stdout, for Generic and when no file flag
+// was given). This is the "sugar" that keeps existing invocations working
+// unchanged when -config isn't set.
+func defaultRules() []compiledRule {
+	base := compiledRule{
+		min:          minlength,
+		max:          maxlength,
+		contains:     filterString,
+		regex:        regex,
+		alphaNumOnly: alphaNumOnly,
+	}
+
+	perType := []struct {
+		t    TokenType
+		file *os.File
+	}{
+		{Subdomain, subdomainFile},
+		{Path, pathFile},
+		{ParamName, paramFile},
+		{ParamValue, paramValueFile},
+		{Fragment, fragmentFile},
+		{Generic, nil},
+	}
+
+	out := make([]compiledRule, 0, len(perType))
+	for _, pt := range perType {
+		r := base
+		r.types = map[TokenType]bool{pt.t: true}
+		r.output = pt.file
+		r.stop = true
+		out = append(out, r)
+	}
+	return out
+}
+
+// evaluateRules walks rules in order for a token of type t, applying each
+// matching rule's filters and, on a pass, writing it to that rule's output.
+// Evaluation continues to the next rule unless the matching rule sets
+// stop, so a single token can fan out to more than one output.
+func evaluateRules(t TokenType, str string) {
+	for i := range rules {
+		r := &rules[i]
+		if !r.matchesType(t) {
+			continue
+		}
+
+		s := str
+		if r.lowercase {
+			s = strings.ToLower(s)
+		}
+		if !r.passes(s) {
+			continue
+		}
+
+		if r.output != nil {
+			fmt.Fprintln(r.output, s)
+		} else {
+			fmt.Println(s)
+		}
+
+		if r.stop {
 			return
 		}
 	}
+}
+
+// ingestCount applies the shared filters and, if the token survives, bumps
+// its count in the per-TokenType frequency map.
+func ingestCount(t TokenType, str string) {
+	if !passesFilters(str) {
+		return
+	}
+
+	m, ok := counts[t]
+	if !ok {
+		m = make(map[string]int)
+		counts[t] = m
+	}
+	m[str]++
+}
+
+// flushCounts is the flush phase for -count mode: for each TokenType it
+// sorts tokens by descending count (ties broken alphabetically), trims to
+// -top N when set, and writes them through the normal per-type output
+// dispatch, optionally prefixed with "count\t" when -with-counts is set.
+func flushCounts() {
+	for t, m := range counts {
+		type tokenCount struct {
+			token string
+			count int
+		}
+
+		entries := make([]tokenCount, 0, len(m))
+		for token, count := range m {
+			entries = append(entries, tokenCount{token, count})
+		}
+
+		sort.Slice(entries, func(i, j int) bool {
+			if entries[i].count != entries[j].count {
+				return entries[i].count > entries[j].count
+			}
+			return entries[i].token < entries[j].token
+		})
+
+		if topN > 0 && len(entries) > topN {
+			entries = entries[:topN]
+		}
+
+		for _, e := range entries {
+			if withCounts {
+				writeToken(t, fmt.Sprintf("%d\t%s", e.count, e.token))
+			} else {
+				writeToken(t, e.token)
+			}
+		}
+	}
+}
+
+// bloomShardCount is the number of independent shards a countingBloomFilter
+// splits its slots into, so concurrent producers contend on a shard lock
+// rather than one filter-wide lock.
+const bloomShardCount = 64
+
+// countingBloomFilter is a sharded, fixed-size counting Bloom filter used by
+// -bloom as a bounded-memory alternative to the exact sync.Map dedup. Each
+// slot is a saturating uint8 counter rather than a single bit, so it can be
+// described as "counting", though seen() only ever inspects/sets it like a
+// bit (never decrements) since tokens are never removed from the stream.
+// Because each slot is a full byte, -bloom-bits sizes the filter in slots
+// (equivalently, bytes of memory) rather than packed bits. This trades a
+// small false-positive rate (tokens that get silently dropped as "already
+// seen") for RSS that is bounded by -bloom-bits regardless of how many
+// distinct tokens are fed through it.
+type countingBloomFilter struct {
+	shards        []bloomShard
+	slotsPerShard uint64
+	k             int
+	seed1         maphash.Seed
+	seed2         maphash.Seed
+}
+
+type bloomShard struct {
+	mu       sync.Mutex
+	counters []uint8
+}
+
+// newCountingBloomFilter allocates a filter with roughly totalSlots
+// one-byte counters, split evenly across bloomShardCount shards,
+// checking/setting k positions per token.
+func newCountingBloomFilter(totalSlots uint64, k int) *countingBloomFilter {
+	if k < 1 {
+		k = 1
+	}
+
+	slotsPerShard := totalSlots / bloomShardCount
+	if slotsPerShard == 0 {
+		slotsPerShard = 1
+	}
+
+	shards := make([]bloomShard, bloomShardCount)
+	for i := range shards {
+		shards[i].counters = make([]uint8, slotsPerShard)
+	}
+
+	return &countingBloomFilter{
+		shards:        shards,
+		slotsPerShard: slotsPerShard,
+		k:             k,
+		seed1:         maphash.MakeSeed(),
+		seed2:         maphash.MakeSeed(),
+	}
+}
+
+// seen reports whether str was already recorded, and records it if not. The
+// shard is picked from the first hash to spread lock contention across
+// shards. The k slot positions within that shard are derived from two
+// independent 64-bit hashes via the Kirsch-Mitzenmacher double-hashing
+// trick (h_i = h1 + i*h2 mod m), so only two hashes are ever computed
+// regardless of k. str is "already seen" only if all k slots were already
+// set; otherwise str is forwarded. Every call increments all k slots
+// (saturating at 255), whether or not str was already seen.
+func (c *countingBloomFilter) seen(str string) bool {
+	h1 := maphash.String(c.seed1, str)
+	h2 := maphash.String(c.seed2, str)
+
+	shard := &c.shards[h1%bloomShardCount]
+
+	positions := make([]uint64, c.k)
+	for i := 0; i < c.k; i++ {
+		positions[i] = (h1 + uint64(i)*h2) % c.slotsPerShard
+	}
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	alreadySet := true
+	for _, pos := range positions {
+		if shard.counters[pos] == 0 {
+			alreadySet = false
+			break
+		}
+	}
+
+	for _, pos := range positions {
+		if shard.counters[pos] < 255 {
+			shard.counters[pos]++
+		}
+	}
+
+	return alreadySet
+}
 
-	// Output dispatch
-	switch token.Type {
+// writeToken dispatches a token to its TokenType's output file, falling
+// back to stdout when no dedicated file was configured for that type.
+func writeToken(t TokenType, str string) {
+	switch t {
+	case Subdomain:
+		if subdomainFile != nil {
+			fmt.Fprintln(subdomainFile, str)
+			return
+		}
 	case Path:
 		if pathFile != nil {
 			fmt.Fprintln(pathFile, str)
@@ -203,8 +831,191 @@ func processToken(token Token) {
 			fmt.Fprintln(paramFile, str)
 			return
 		}
+	case ParamValue:
+		if paramValueFile != nil {
+			fmt.Fprintln(paramValueFile, str)
+			return
+		}
+	case Fragment:
+		if fragmentFile != nil {
+			fmt.Fprintln(fragmentFile, str)
+			return
+		}
 	}
 
 	// Default to stdout
 	fmt.Println(str)
 }
+
+// substringSentinel separates tokens inside substringMiner's buffer. It is
+// never produced by subTokenize, which only ever emits runs of letters and
+// digits, so it can't accidentally appear inside a mined substring.
+const substringSentinel = 0x00
+
+// substringMiner accumulates every token observed (along with the id of the
+// line it came from) into a single byte buffer for -mine-substrings: buf is
+// every token's bytes separated by substringSentinel, and lineOf[i] is the
+// source line id of buf[i].
+type substringMiner struct {
+	buf    []byte
+	lineOf []int
+}
+
+func (m *substringMiner) add(token string, line int) {
+	start := len(m.buf)
+	m.buf = append(m.buf, token...)
+	for range m.buf[start:] {
+		m.lineOf = append(m.lineOf, line)
+	}
+	m.buf = append(m.buf, substringSentinel)
+	m.lineOf = append(m.lineOf, line)
+}
+
+// flushSubstringMining is the -mine-substrings flush phase: it builds a
+// suffix array over every token collected by miner, derives the LCP array
+// via Kasai's algorithm, and walks it to find substrings of at least
+// -mine-min-len bytes that recur across at least -mine-min-docs distinct
+// source lines, printing each with its document frequency.
+func flushSubstringMining() {
+	buf := miner.buf
+	n := len(buf)
+	if n == 0 {
+		return
+	}
+
+	sa := buildSuffixArray(buf)
+	lcp := kasaiLCP(buf, sa)
+
+	seen := make(map[string]bool)
+	i := 0
+	for i < n {
+		// Find the maximal run of adjacent suffixes in sa that all share a
+		// common prefix of at least -mine-min-len bytes, tracking the
+		// minimum LCP across the run as we go: that minimum is the actual
+		// length of the prefix shared by every suffix in the run (LCP
+		// values only bound adjacent pairs, so the run's true shared
+		// length is its smallest link, not -mine-min-len itself).
+		j := i
+		sharedLen := -1
+		for j+1 < n && lcp[j+1] >= mineMinLen {
+			j++
+			if sharedLen == -1 || lcp[j] < sharedLen {
+				sharedLen = lcp[j]
+			}
+		}
+
+		if j > i {
+			// The shared prefix can run past a token's end into the
+			// substringSentinel separating it from the next token (e.g.
+			// two "users" tokens both followed by a sentinel match one
+			// more byte than the word itself). Clamp to the sentinel so
+			// the reported substring never includes it.
+			if idx := bytes.IndexByte(buf[sa[i]:sa[i]+sharedLen], substringSentinel); idx >= 0 {
+				sharedLen = idx
+			}
+
+			if sharedLen >= mineMinLen {
+				substr := buf[sa[i] : sa[i]+sharedLen]
+				docs := make(map[int]bool)
+				for _, pos := range sa[i : j+1] {
+					docs[miner.lineOf[pos]] = true
+				}
+				if len(docs) >= mineMinDocs {
+					s := string(substr)
+					if !seen[s] && passesFilters(s) {
+						seen[s] = true
+						fmt.Printf("%d\t%s\n", len(docs), s)
+					}
+				}
+			}
+			i = j + 1 // skip past this run, it's already been reported
+		} else {
+			i++
+		}
+	}
+}
+
+// buildSuffixArray returns the indices of data's suffixes in sorted order,
+// built via prefix doubling: round k sorts suffixes by their rank over the
+// first 2^k bytes, derived from round k-1's ranks in O(1) per comparison,
+// instead of comparing full remaining-suffix byte ranges. That keeps each
+// round's sort at O(n log n) comparisons (vs. the O(n) per comparison that
+// a bytes.Compare-based sort pays, which is worst-case quadratic on exactly
+// the kind of corpora this mode targets: many long, recurring substrings).
+// index/suffixarray.Index deliberately doesn't expose its internal sorted
+// array (only Lookup/FindAllIndex), so flushSubstringMining needs its own
+// to walk in SA order and compute an LCP array over it.
+func buildSuffixArray(data []byte) []int {
+	n := len(data)
+	sa := make([]int, n)
+	rank := make([]int, n)
+	for i := 0; i < n; i++ {
+		sa[i] = i
+		rank[i] = int(data[i])
+	}
+	if n == 0 {
+		return sa
+	}
+
+	next := make([]int, n)
+	rankAt := func(i, k int) int {
+		if i+k < n {
+			return rank[i+k]
+		}
+		return -1
+	}
+
+	for k := 1; ; k *= 2 {
+		sort.Slice(sa, func(a, b int) bool {
+			i, j := sa[a], sa[b]
+			if rank[i] != rank[j] {
+				return rank[i] < rank[j]
+			}
+			return rankAt(i, k) < rankAt(j, k)
+		})
+
+		next[sa[0]] = 0
+		for i := 1; i < n; i++ {
+			next[sa[i]] = next[sa[i-1]]
+			prev, cur := sa[i-1], sa[i]
+			if rank[prev] != rank[cur] || rankAt(prev, k) != rankAt(cur, k) {
+				next[sa[i]]++
+			}
+		}
+		rank, next = next, rank
+
+		if rank[sa[n-1]] == n-1 {
+			break
+		}
+	}
+	return sa
+}
+
+// kasaiLCP computes the LCP array for data's suffixes in the order given by
+// sa, using Kasai's algorithm: lcp[k] is the length of the common prefix
+// shared by the suffixes at sa[k-1] and sa[k] (lcp[0] is unused).
+func kasaiLCP(data []byte, sa []int) []int {
+	n := len(data)
+	rank := make([]int, n)
+	for i, s := range sa {
+		rank[s] = i
+	}
+
+	lcp := make([]int, n)
+	h := 0
+	for i := 0; i < n; i++ {
+		if rank[i] > 0 {
+			j := sa[rank[i]-1]
+			for i+h < n && j+h < n && data[i+h] == data[j+h] {
+				h++
+			}
+			lcp[rank[i]] = h
+			if h > 0 {
+				h--
+			}
+		} else {
+			h = 0
+		}
+	}
+	return lcp
+}